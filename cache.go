@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// cacheTTL is how long a cached GitState is considered fresh enough that we
+// don't even bother kicking off a background refresh for it.
+const cacheTTL = 1 * time.Second
+
+// cacheEntry is the on-disk shape of a repo's cache file.
+type cacheEntry struct {
+	State     *GitState `json:"state"`
+	Timestamp int64     `json:"timestamp"`
+}
+
+// cacheDir returns the directory `line` stores its per-repo git state cache
+// in.
+func cacheDir() string {
+	cacheHome := os.Getenv("XDG_CACHE_HOME")
+	if cacheHome == "" {
+		cacheHome = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+
+	return filepath.Join(cacheHome, "line")
+}
+
+// cachePath returns the cache file used for the repo rooted at repoRoot,
+// named after a hash of its path so different repos don't collide.
+func cachePath(repoRoot string) string {
+	sum := md5.Sum([]byte(repoRoot))
+	return filepath.Join(cacheDir(), hex.EncodeToString(sum[:])+".json")
+}
+
+// readCache reads the cached GitState for a repo, along with whether it's
+// still within cacheTTL. A cache miss or parse failure returns (nil, false).
+func readCache(path string) (*GitState, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, false
+	}
+
+	age := time.Since(time.Unix(entry.Timestamp, 0))
+	return entry.State, age < cacheTTL
+}
+
+// writeCache atomically writes state to the given cache file.
+func writeCache(path string, state *GitState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(cacheEntry{State: state, Timestamp: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// resolveGitState returns the GitState to render the prompt with for the
+// repo rooted at repoRoot. Unless sync is set, it prefers an immediate
+// answer over an up-to-date one: a fresh-enough cache entry is returned as
+// is, a stale one is returned immediately while a background process
+// recomputes it for next time, and only a totally cold cache blocks (up to
+// timeout, if given) while we compute state synchronously.
+func resolveGitState(repoRoot string, sync bool, timeout time.Duration) *GitState {
+	if sync {
+		state, _ := ReadGitState(repoRoot)
+		return state
+	}
+
+	path := cachePath(repoRoot)
+	cached, fresh := readCache(path)
+	if fresh {
+		return cached
+	}
+
+	refreshCacheInBackground(repoRoot)
+
+	if cached != nil {
+		return cached
+	}
+
+	// nothing cached yet for this repo - compute synchronously, respecting the
+	// caller's timeout budget if they gave us one.
+	if timeout <= 0 {
+		state, _ := ReadGitState(repoRoot)
+		return state
+	}
+
+	result := make(chan *GitState, 1)
+	go func() {
+		state, _ := ReadGitState(repoRoot)
+		result <- state
+	}()
+
+	select {
+	case state := <-result:
+		return state
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+// refreshCacheInBackground forks a detached copy of the current process to
+// recompute repoRoot's git state and rewrite its cache file, so the next
+// invocation of `line` sees fresh data without this invocation having to
+// wait for it.
+func refreshCacheInBackground(repoRoot string) {
+	self, err := os.Executable()
+	if err != nil {
+		return
+	}
+
+	cmd := exec.Command(self, "-refresh-cache-for", repoRoot)
+
+	// detach fully: no inherited stdio, and its own session so it isn't killed
+	// when the parent shell's foreground process group is.
+	cmd.Stdin = nil
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	// Start, don't Run - we want to return immediately without waiting for it
+	cmd.Start()
+}
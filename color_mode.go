@@ -0,0 +1,189 @@
+package main
+
+import (
+	"math"
+	"os"
+	"strings"
+)
+
+// ColorMode is how much of the terminal's color range we're allowed to use.
+// Render degrades a requested hex color down to whichever of these is
+// detected, so the prompt looks right everywhere from a truecolor terminal
+// to a CI log being captured to a plain file.
+type ColorMode int
+
+const (
+	ColorNone ColorMode = iota
+	Color16
+	Color256
+	ColorTrue
+)
+
+// detectColorMode figures out how much color the current terminal supports,
+// from $NO_COLOR, $COLORTERM, $TERM, and whether stdout is even a terminal
+// at all. requireTTY should be false when the output is headed for shell
+// embedding (a non-plain --shell) rather than straight to a terminal: the
+// canonical `export PS1="$(line --shell=bash)"` runs `line` inside command
+// substitution, where stdout is always a pipe, so gating on isTTY there
+// would always force ColorNone and defeat --shell entirely.
+func detectColorMode(requireTTY bool) ColorMode {
+	// $NO_COLOR is the de-facto standard opt-out: its mere presence disables
+	// color, regardless of its value.
+	if _, set := os.LookupEnv("NO_COLOR"); set {
+		return ColorNone
+	}
+
+	// piping straight to a file or another program (no shell embedding
+	// requested) should produce clean text
+	if requireTTY && !isTTY(os.Stdout) {
+		return ColorNone
+	}
+
+	switch os.Getenv("COLORTERM") {
+	case "truecolor", "24bit":
+		return ColorTrue
+	}
+
+	term := os.Getenv("TERM")
+	switch {
+	case term == "" || term == "dumb":
+		return ColorNone
+	case strings.Contains(term, "256color"):
+		return Color256
+	default:
+		return Color16
+	}
+}
+
+// isTTY reports whether f is attached to a terminal rather than a file or
+// pipe.
+func isTTY(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// hexTo256 quantizes a 24-bit color down to the nearest xterm 256-color
+// palette index, using the 6x6x6 color cube (16-231) for chromatic colors
+// and the 24-step grayscale ramp (232-255) for colors close to gray.
+func hexTo256(hexColor int) int {
+	r, g, b := hexToRGB(hexColor)
+
+	if isGray(r, g, b) {
+		gray := (r + g + b) / 3
+
+		if gray < 8 {
+			return 16
+		}
+		if gray > 248 {
+			return 231
+		}
+
+		return 232 + int(math.Round(float64(gray-8)/247*24))
+	}
+
+	toCubeIndex := func(c int) int {
+		return int(math.Round(float64(c) / 255 * 5))
+	}
+
+	ri, gi, bi := toCubeIndex(r), toCubeIndex(g), toCubeIndex(b)
+	return 16 + 36*ri + 6*gi + bi
+}
+
+// isGray reports whether r, g, b are close enough to each other that they'll
+// look better quantized to the grayscale ramp than to the color cube.
+func isGray(r, g, b int) bool {
+	const tolerance = 8
+	return absInt(r-g) < tolerance && absInt(g-b) < tolerance && absInt(r-b) < tolerance
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// ansi16Palette is the standard (if only loosely standardized) hex value of
+// each of the 16 ANSI colors, in SGR code order: 0-7 are the normal colors,
+// 8-15 are their bright counterparts.
+var ansi16Palette = [16]int{
+	0x000000, 0xcd0000, 0x00cd00, 0xcdcd00, 0x0000ee, 0xcd00cd, 0x00cdcd, 0xe5e5e5,
+	0x7f7f7f, 0xff0000, 0x00ff00, 0xffff00, 0x5c5cff, 0xff00ff, 0x00ffff, 0xffffff,
+}
+
+// hexTo16 returns the index (0-15) of the ANSI-16 palette entry nearest
+// hexColor, comparing colors in CIE-Lab space since it's a much better match
+// for perceived distance than raw RGB.
+func hexTo16(hexColor int) int {
+	r, g, b := hexToRGB(hexColor)
+	targetL, targetA, targetBB := rgbToLab(r, g, b)
+
+	best := 0
+	bestDist := math.Inf(1)
+
+	for i, hex := range ansi16Palette {
+		pr, pg, pb := hexToRGB(hex)
+		l, a, bb := rgbToLab(pr, pg, pb)
+
+		dl, da, db := l-targetL, a-targetA, bb-targetBB
+		dist := dl*dl + da*da + db*db
+
+		if dist < bestDist {
+			bestDist = dist
+			best = i
+		}
+	}
+
+	return best
+}
+
+// ansi16Code converts an ansi16Palette index (0-15) into its SGR foreground
+// color code: 30-37 for the normal colors, 90-97 for the bright ones.
+func ansi16Code(index int) int {
+	if index < 8 {
+		return 30 + index
+	}
+	return 90 + (index - 8)
+}
+
+// rgbToLab converts 8-bit sRGB to CIE-Lab (D65 white point), which is what
+// makes hexTo16's nearest-color search perceptually meaningful instead of
+// just minimizing raw RGB distance.
+func rgbToLab(r, g, b int) (float64, float64, float64) {
+	toLinear := func(c int) float64 {
+		cf := float64(c) / 255
+		if cf > 0.04045 {
+			return math.Pow((cf+0.055)/1.055, 2.4)
+		}
+		return cf / 12.92
+	}
+
+	rl, gl, bl := toLinear(r), toLinear(g), toLinear(b)
+
+	// sRGB -> XYZ (D65)
+	x := rl*0.4124564 + gl*0.3575761 + bl*0.1804375
+	y := rl*0.2126729 + gl*0.7151522 + bl*0.0721750
+	z := rl*0.0193339 + gl*0.1191920 + bl*0.9503041
+
+	// normalize by the D65 reference white, then to Lab
+	const xn, yn, zn = 0.95047, 1.0, 1.08883
+
+	f := func(t float64) float64 {
+		if t > 0.008856 {
+			return math.Cbrt(t)
+		}
+		return (7.787 * t) + (16.0 / 116.0)
+	}
+
+	fx, fy, fz := f(x/xn), f(y/yn), f(z/zn)
+
+	l := (116 * fy) - 16
+	a := 500 * (fx - fy)
+	bb := 200 * (fy - fz)
+
+	return l, a, bb
+}
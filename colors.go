@@ -11,16 +11,18 @@ const COLOR_RESET = COLOR_ESCAPE + "[0m"
 const COLOR_CODE_FOREGROUND = 38
 const COLOR_CODE_BACKGROUND = 48
 
-// standard colors
+// standard colors, as their usual xterm hex values. These double as the hex
+// colors we ask for in true-color mode and as the targets we quantize down
+// to when only a 256- or 16-color palette is available.
 const (
-	COLOR_BLACK   = 0
-	COLOR_RED     = 1
-	COLOR_GREEN   = 2
-	COLOR_YELLOW  = 3
-	COLOR_BLUE    = 4
-	COLOR_MAGENTA = 5
-	COLOR_CYAN    = 6
-	COLOR_WHITE   = 7
+	COLOR_BLACK   = 0x000000
+	COLOR_RED     = 0xcd0000
+	COLOR_GREEN   = 0x00cd00
+	COLOR_YELLOW  = 0xcdcd00
+	COLOR_BLUE    = 0x0000ee
+	COLOR_MAGENTA = 0xcd00cd
+	COLOR_CYAN    = 0x00cdcd
+	COLOR_WHITE   = 0xe5e5e5
 )
 
 // converts an RGB color to hex
@@ -130,25 +132,28 @@ func hslToRGB(h, s, l float64) (int, int, int) {
 	return int(rf * 255), int(gf * 255), int(bf * 255)
 }
 
-func color(colorCode int) string {
-	return fmt.Sprintf("%s[3%dm", COLOR_ESCAPE, colorCode)
+// given a hex foreground color, returns s wrapped in the escape sequences
+// needed to display it in the terminal's detected ColorMode, with no
+// trailing reset if nothing was written in the first place (e.g. ColorNone,
+// or output that isn't a terminal at all). Routes through activeWriter so
+// the escapes come out wrapped correctly for the target shell.
+func colored(s string, hexColor int) string {
+	return activeWriter.Colored(s, hexColor)
 }
 
-func colored(s string, colorCode int) string {
-	fg := color(colorCode)
-	return fg + s + COLOR_RESET
-}
-
-// given a hex color, turns it into a true-color xterm escape sequence using
-// semicolons as parameter delimiters, with no background color.
-func trueColor(hexColor, specifierCode int) string {
-	r, g, b := hexToRGB(hexColor)
-	return fmt.Sprintf("%s[%d;2;%d;%d;%dm", COLOR_ESCAPE, specifierCode, r, g, b)
-}
-
-// given a string, returns the string in the given color using xterm true-color
-// escape codes.
-func trueColored(s string, hexColor int) string {
-	fg := trueColor(hexColor, COLOR_CODE_FOREGROUND)
-	return fg + s + COLOR_RESET
+// Render returns the raw escape sequence that sets the foreground color to
+// hexColor under the given ColorMode, quantizing down to that mode's palette
+// as necessary. It returns "" for ColorNone.
+func Render(hexColor int, mode ColorMode) string {
+	switch mode {
+	case ColorTrue:
+		r, g, b := hexToRGB(hexColor)
+		return fmt.Sprintf("%s[%d;2;%d;%d;%dm", COLOR_ESCAPE, COLOR_CODE_FOREGROUND, r, g, b)
+	case Color256:
+		return fmt.Sprintf("%s[%d;5;%dm", COLOR_ESCAPE, COLOR_CODE_FOREGROUND, hexTo256(hexColor))
+	case Color16:
+		return fmt.Sprintf("%s[%dm", COLOR_ESCAPE, ansi16Code(hexTo16(hexColor)))
+	default:
+		return ""
+	}
 }
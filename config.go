@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// SegmentConfig describes a single configured segment: which built-in
+// segment to render, and how to decorate its output. Color is a pointer so
+// we can tell "not configured" apart from the zero color value.
+type SegmentConfig struct {
+	Type   string `json:"type"`
+	Color  *int   `json:"color,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+}
+
+// Config is the top-level shape of `$XDG_CONFIG_HOME/line/config.json`. The
+// Segments list is rendered in order, so reordering, dropping, or duplicating
+// entries reorders, hides, or duplicates the corresponding prompt segments.
+type Config struct {
+	Segments []SegmentConfig `json:"segments"`
+}
+
+// defaultConfig reproduces the prompt's original hard-coded layout, plus the
+// git_status segment, and is used whenever no user config file is found or it
+// fails to parse.
+func defaultConfig() *Config {
+	magenta := COLOR_MAGENTA
+	blue := COLOR_BLUE
+
+	return &Config{
+		Segments: []SegmentConfig{
+			{Type: "time", Color: &magenta},
+			{Type: "userhost"},
+			{Type: "directory", Color: &blue},
+			{Type: "git_branch"},
+			{Type: "git_status"},
+		},
+	}
+}
+
+// configPath returns the path `line` reads its configuration from.
+func configPath() string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = path.Join(os.Getenv("HOME"), ".config")
+	}
+
+	return path.Join(configHome, "line", "config.json")
+}
+
+// loadConfig reads and parses the user's config file, falling back to
+// defaultConfig if it's missing or invalid.
+func loadConfig() *Config {
+	data, err := ioutil.ReadFile(configPath())
+	if err != nil {
+		return defaultConfig()
+	}
+
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return defaultConfig()
+	}
+
+	return cfg
+}
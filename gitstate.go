@@ -0,0 +1,767 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GitState is everything the prompt needs to know about the state of a Git
+// repository, read directly from the on-disk Git objects rather than by
+// shelling out to `git`.
+type GitState struct {
+	Branch     string
+	Upstream   string
+	Ahead      int
+	Behind     int
+	Staged     int
+	Unstaged   int
+	Untracked  int
+	Conflicted int
+	Stashed    int
+	Operation  string
+	Detached   bool
+}
+
+// Possible values of GitState.Operation, naming an in-progress operation
+// that's left the working tree in a special state.
+const (
+	OperationMerge      = "merging"
+	OperationRebase     = "rebasing"
+	OperationCherryPick = "cherry-picking"
+)
+
+// maxAncestorWalk bounds how many commits we'll walk per side when computing
+// ahead/behind counts, so a branch with a huge amount of history can't make
+// every prompt render slow.
+const maxAncestorWalk = 1000
+
+// ReadGitState reads the Git repository rooted at repoRoot (the working
+// tree's top-level directory, i.e. the parent of its `.git` directory) and
+// returns its current branch, upstream tracking info, and working-tree
+// status, or an error if repoRoot isn't a Git repository.
+func ReadGitState(repoRoot string) (*GitState, error) {
+	gitDir := filepath.Join(repoRoot, ".git")
+
+	if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("no Git directory at %s", gitDir)
+	}
+
+	state := &GitState{}
+
+	headSHA, err := readHead(gitDir, state)
+	if err != nil {
+		return nil, err
+	}
+
+	readUpstream(gitDir, repoRoot, state, headSHA)
+	readWorkingTreeStatus(gitDir, repoRoot, state)
+	state.Stashed = readStashCount(gitDir)
+	state.Operation = readOperationInProgress(gitDir)
+
+	return state, nil
+}
+
+// readStashCount returns the number of entries in the stash, by counting
+// lines in the stash ref's reflog - each stash push appends one line there.
+//
+// This is a deliberate departure from `git rev-parse --verify refs/stash`:
+// that only confirms the stash ref exists at all (0 vs. "at least 1"), it
+// doesn't give a count, and getting a real count out of `git` would mean an
+// extra `git stash list | wc -l` invocation. The reflog already has one line
+// per stash entry sitting right there on disk, consistent with this file's
+// native-read approach everywhere else.
+func readStashCount(gitDir string) int {
+	data, err := ioutil.ReadFile(filepath.Join(gitDir, "logs", "refs", "stash"))
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+
+	return count
+}
+
+// readOperationInProgress detects whether the repository is in the middle of
+// a merge, rebase, or cherry-pick, by checking for the marker files/dirs Git
+// itself uses to track that state.
+func readOperationInProgress(gitDir string) string {
+	if fileExists(filepath.Join(gitDir, "MERGE_HEAD")) {
+		return OperationMerge
+	}
+
+	if fileExists(filepath.Join(gitDir, "rebase-merge")) ||
+		fileExists(filepath.Join(gitDir, "rebase-apply")) {
+		return OperationRebase
+	}
+
+	if fileExists(filepath.Join(gitDir, "CHERRY_PICK_HEAD")) {
+		return OperationCherryPick
+	}
+
+	return ""
+}
+
+// fileExists reports whether path exists, regardless of type.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// readHead parses `.git/HEAD`, filling in state.Branch and state.Detached,
+// and returns the SHA-1 of the commit it points at.
+func readHead(gitDir string, state *GitState) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+
+	refSpec := strings.TrimSpace(string(data))
+
+	const refPrefix = "ref: "
+	if strings.HasPrefix(refSpec, refPrefix) {
+		ref := strings.TrimPrefix(refSpec, refPrefix)
+		state.Branch = strings.TrimPrefix(ref, "refs/heads/")
+		state.Detached = false
+
+		sha, err := resolveRef(gitDir, ref)
+		if err != nil {
+			// an unborn branch (no commits yet) has no resolvable ref
+			return "", nil
+		}
+		return sha, nil
+	}
+
+	// otherwise HEAD points directly at a commit, i.e. we're detached
+	state.Detached = true
+	if len(refSpec) >= 7 {
+		state.Branch = refSpec[0:7]
+	} else {
+		state.Branch = refSpec
+	}
+	return refSpec, nil
+}
+
+// readUpstream looks up the branch's configured upstream in `.git/config`,
+// resolves it to a commit, and fills in state.Upstream, state.Ahead, and
+// state.Behind.
+func readUpstream(gitDir, repoRoot string, state *GitState, headSHA string) {
+	if state.Detached || state.Branch == "" {
+		return
+	}
+
+	remote, mergeRef, ok := readBranchUpstreamConfig(gitDir, state.Branch)
+	if !ok {
+		return
+	}
+
+	upstreamBranch := strings.TrimPrefix(mergeRef, "refs/heads/")
+
+	// remote == "." means the branch tracks another local branch (as set by
+	// `git branch --set-upstream-to=<localbranch>`), not a remote-tracking
+	// ref - mergeRef is already the local ref to resolve in that case.
+	var upstreamRef, upstreamName string
+	if remote == "." {
+		upstreamRef = mergeRef
+		upstreamName = upstreamBranch
+	} else {
+		upstreamRef = "refs/remotes/" + remote + "/" + upstreamBranch
+		upstreamName = remote + "/" + upstreamBranch
+	}
+
+	upstreamSHA, err := resolveRef(gitDir, upstreamRef)
+	if err != nil {
+		return
+	}
+
+	state.Upstream = upstreamName
+
+	if headSHA == "" {
+		return
+	}
+
+	ahead, behind := countAheadBehind(gitDir, repoRoot, headSHA, upstreamSHA)
+	state.Ahead = ahead
+	state.Behind = behind
+}
+
+// readBranchUpstreamConfig finds the `remote` and `merge` settings for the
+// given branch's `[branch "<name>"]` section in `.git/config`.
+func readBranchUpstreamConfig(gitDir, branch string) (remote, mergeRef string, ok bool) {
+	data, err := ioutil.ReadFile(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return "", "", false
+	}
+
+	section := fmt.Sprintf(`branch "%s"`, branch)
+	inSection := false
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "[") {
+			inSection = strings.EqualFold(strings.Trim(line, "[]"), section)
+			continue
+		}
+
+		if !inSection {
+			continue
+		}
+
+		if key, value, found := strings.Cut(line, "="); found {
+			switch strings.TrimSpace(key) {
+			case "remote":
+				remote = strings.TrimSpace(value)
+			case "merge":
+				mergeRef = strings.TrimSpace(value)
+			}
+		}
+	}
+
+	return remote, mergeRef, remote != "" && mergeRef != ""
+}
+
+// resolveRef resolves a ref name (e.g. "refs/heads/master") to a commit
+// SHA-1, checking loose refs under .git/ first and falling back to
+// .git/packed-refs.
+func resolveRef(gitDir, ref string) (string, error) {
+	if data, err := ioutil.ReadFile(filepath.Join(gitDir, ref)); err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve ref %s", ref)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) == 2 && parts[1] == ref {
+			return parts[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("unable to resolve ref %s", ref)
+}
+
+// countAheadBehind walks the commit graphs reachable from `local` and
+// `upstream`, comparing them to determine how many commits each side has
+// that the other doesn't. This only works while every commit involved is a
+// loose object; as soon as the walk runs into a packed one (the common case
+// after a `git gc` or a fresh clone) it gives up on the native walk entirely
+// and asks `git` directly for the authoritative counts, rather than
+// reporting a confidently wrong lower bound.
+func countAheadBehind(gitDir, repoRoot, local, upstream string) (ahead int, behind int) {
+	if local == upstream {
+		return 0, 0
+	}
+
+	localAncestors, localComplete := walkAncestors(gitDir, local, maxAncestorWalk)
+	upstreamAncestors, upstreamComplete := walkAncestors(gitDir, upstream, maxAncestorWalk)
+
+	if !localComplete || !upstreamComplete {
+		if a, b, err := aheadBehindViaGit(repoRoot, local, upstream); err == nil {
+			return a, b
+		}
+		return 0, 0
+	}
+
+	for sha := range localAncestors {
+		if _, inUpstream := upstreamAncestors[sha]; !inUpstream {
+			ahead++
+		}
+	}
+
+	for sha := range upstreamAncestors {
+		if _, inLocal := localAncestors[sha]; !inLocal {
+			behind++
+		}
+	}
+
+	return ahead, behind
+}
+
+// walkAncestors breadth-first walks commit parents starting at sha, up to
+// limit commits, returning the set of commit SHAs it visited and whether the
+// walk reached every ancestor (false if it hit a packed/missing object or
+// the walk limit before doing so).
+func walkAncestors(gitDir, sha string, limit int) (map[string]bool, bool) {
+	visited := map[string]bool{}
+	queue := []string{sha}
+	complete := true
+
+	for len(queue) > 0 {
+		if len(visited) >= limit {
+			complete = false
+			break
+		}
+
+		cur := queue[0]
+		queue = queue[1:]
+
+		if visited[cur] {
+			continue
+		}
+		visited[cur] = true
+
+		objType, content, err := readLooseObject(gitDir, cur)
+		if err != nil {
+			// packed (or missing) object - we can't see past it
+			complete = false
+			continue
+		}
+		if objType != "commit" {
+			continue
+		}
+
+		for _, parent := range parseCommitParents(content) {
+			if !visited[parent] {
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	return visited, complete
+}
+
+// aheadBehindViaGit shells out to `git rev-list` for the ahead/behind counts
+// between local and upstream, used as a fallback once the native commit walk
+// can no longer see the whole graph as loose objects.
+func aheadBehindViaGit(repoRoot, local, upstream string) (int, int, error) {
+	out, err := exec.Command("git", "-C", repoRoot, "rev-list", "--left-right", "--count", local+"..."+upstream).Output()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fields := strings.Fields(strings.TrimSpace(string(out)))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected `git rev-list` output: %q", out)
+	}
+
+	ahead, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	behind, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return ahead, behind, nil
+}
+
+// parseCommitParents extracts the parent commit SHAs from a raw commit
+// object's content.
+func parseCommitParents(content []byte) []string {
+	parents := []string{}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" {
+			// the blank line ends the commit header and starts the message
+			break
+		}
+
+		if rest, found := strings.CutPrefix(line, "parent "); found {
+			parents = append(parents, strings.TrimSpace(rest))
+		}
+	}
+
+	return parents
+}
+
+// readLooseObject reads and zlib-inflates the loose object identified by
+// sha from .git/objects, returning its type ("blob", "tree", "commit", ...)
+// and content.
+func readLooseObject(gitDir, sha string) (string, []byte, error) {
+	if len(sha) != 40 {
+		return "", nil, fmt.Errorf("invalid object id %q", sha)
+	}
+
+	objPath := filepath.Join(gitDir, "objects", sha[0:2], sha[2:])
+
+	f, err := os.Open(objPath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	nul := bytes.IndexByte(raw, 0)
+	if nul < 0 {
+		return "", nil, fmt.Errorf("malformed object %s", sha)
+	}
+
+	header := strings.SplitN(string(raw[:nul]), " ", 2)
+	if len(header) != 2 {
+		return "", nil, fmt.Errorf("malformed object header in %s", sha)
+	}
+
+	return header[0], raw[nul+1:], nil
+}
+
+// hashBlob computes the Git object id for the given file content, i.e.
+// sha1("blob <size>\x00" + content).
+func hashBlob(content []byte) string {
+	header := fmt.Sprintf("blob %d\x00", len(content))
+
+	h := sha1.New()
+	io.WriteString(h, header)
+	h.Write(content)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// indexEntry is the subset of a Git index entry we need to diff the working
+// tree and HEAD against it.
+type indexEntry struct {
+	mtimeSec  uint32
+	mtimeNano uint32
+	mode      uint32
+	size      uint32
+	sha       string
+	stage     int
+	path      string
+}
+
+// readIndex parses `.git/index` (format version 2 or 3) into its entries.
+func readIndex(gitDir string) ([]indexEntry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(gitDir, "index"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 12 || string(data[0:4]) != "DIRC" {
+		return nil, fmt.Errorf("not a Git index file")
+	}
+
+	version := binary.BigEndian.Uint32(data[4:8])
+	numEntries := binary.BigEndian.Uint32(data[8:12])
+
+	entries := make([]indexEntry, 0, numEntries)
+	offset := 12
+
+	for i := uint32(0); i < numEntries; i++ {
+		if offset+62 > len(data) {
+			break
+		}
+
+		entryStart := offset
+
+		mtimeSec := binary.BigEndian.Uint32(data[offset+8 : offset+12])
+		mtimeNano := binary.BigEndian.Uint32(data[offset+12 : offset+16])
+		mode := binary.BigEndian.Uint32(data[offset+24 : offset+28])
+		size := binary.BigEndian.Uint32(data[offset+36 : offset+40])
+		sha := hex.EncodeToString(data[offset+40 : offset+60])
+		flags := binary.BigEndian.Uint16(data[offset+60 : offset+62])
+
+		stage := int((flags >> 12) & 0x3)
+		nameLen := int(flags & 0x0fff)
+		extended := flags&0x4000 != 0
+
+		nameStart := offset + 62
+		if version == 3 && extended {
+			nameStart += 2
+		}
+
+		if nameStart+nameLen > len(data) {
+			break
+		}
+		name := string(data[nameStart : nameStart+nameLen])
+
+		// entries are padded with NUL bytes to a multiple of 8 bytes, measured
+		// from entryStart, and always reserve at least one NUL terminator after
+		// the name - so a name that already lands on an 8-byte boundary still
+		// gets a full 8 bytes of padding, not zero.
+		entryLen := nameStart + nameLen - entryStart
+		paddedLen := (entryLen + 8) &^ 7
+
+		entries = append(entries, indexEntry{
+			mtimeSec:  mtimeSec,
+			mtimeNano: mtimeNano,
+			mode:      mode,
+			size:      size,
+			sha:       sha,
+			stage:     stage,
+			path:      name,
+		})
+
+		offset = entryStart + paddedLen
+	}
+
+	return entries, nil
+}
+
+// readWorkingTreeStatus compares the index against both the working tree and
+// HEAD's tree, filling in state.Staged, state.Unstaged, state.Untracked, and
+// state.Conflicted.
+func readWorkingTreeStatus(gitDir, repoRoot string, state *GitState) {
+	entries, err := readIndex(gitDir)
+	if err != nil {
+		return
+	}
+
+	headTree := readHeadTree(gitDir, repoRoot)
+	indexed := map[string]bool{}
+	conflicted := map[string]bool{}
+
+	for _, entry := range entries {
+		indexed[entry.path] = true
+
+		if entry.stage != 0 {
+			// a conflicted file has up to three stage entries (base, ours,
+			// theirs) sharing the same path - count the path once, not once
+			// per stage.
+			conflicted[entry.path] = true
+			continue
+		}
+
+		if headSHA, tracked := headTree[entry.path]; !tracked || headSHA != entry.sha {
+			state.Staged++
+		}
+
+		if workingTreeModified(repoRoot, entry) {
+			state.Unstaged++
+		}
+	}
+
+	state.Conflicted = len(conflicted)
+	state.Untracked = countUntrackedFiles(repoRoot, indexed)
+}
+
+// workingTreeModified reports whether the working tree's copy of entry's
+// file differs from what's recorded in the index. It trusts a stat-info
+// match (mtime, size, mode) as "unchanged", only falling back to hashing the
+// file's content when the stat info has changed, exactly as `git status`
+// does to avoid hashing every file on every invocation.
+func workingTreeModified(repoRoot string, entry indexEntry) bool {
+	info, err := os.Lstat(filepath.Join(repoRoot, entry.path))
+	if err != nil {
+		// the file is gone - that's a modification (a deletion)
+		return true
+	}
+
+	if uint32(info.Size()) == entry.size &&
+		uint32(info.ModTime().Unix()) == entry.mtimeSec &&
+		modeOf(info) == entry.mode {
+		return false
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(repoRoot, entry.path))
+	if err != nil {
+		return true
+	}
+
+	return hashBlob(content) != entry.sha
+}
+
+// modeOf converts a file's Go FileMode into the mode bits Git stores in the
+// index (100644 for regular files, 100755 for executable, 120000 for
+// symlinks).
+func modeOf(info os.FileInfo) uint32 {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return 0120000
+	}
+	if info.Mode()&0111 != 0 {
+		return 0100755
+	}
+	return 0100644
+}
+
+// readHeadTree reads HEAD's commit and recursively flattens its tree into a
+// map of repo-relative path to blob SHA-1. If any object along the way turns
+// out to be packed rather than loose, it falls back to a single `git
+// ls-tree` call for the whole tree rather than silently returning a partial
+// (and therefore wrong) map - a partial HEAD tree makes every file after the
+// gap look incorrectly staged.
+func readHeadTree(gitDir, repoRoot string) map[string]string {
+	tree := map[string]string{}
+
+	head, err := ioutil.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return tree
+	}
+
+	refSpec := strings.TrimSpace(string(head))
+	sha := refSpec
+	if strings.HasPrefix(refSpec, "ref: ") {
+		resolved, err := resolveRef(gitDir, strings.TrimPrefix(refSpec, "ref: "))
+		if err != nil {
+			return tree
+		}
+		sha = resolved
+	}
+
+	objType, content, err := readLooseObject(gitDir, sha)
+	if err != nil || objType != "commit" {
+		return headTreeViaGit(repoRoot, sha)
+	}
+
+	treeSHA := ""
+	for _, line := range strings.Split(string(content), "\n") {
+		if rest, found := strings.CutPrefix(line, "tree "); found {
+			treeSHA = strings.TrimSpace(rest)
+			break
+		}
+	}
+
+	if treeSHA == "" {
+		return tree
+	}
+
+	if !flattenTree(gitDir, treeSHA, "", tree) {
+		return headTreeViaGit(repoRoot, sha)
+	}
+
+	return tree
+}
+
+// flattenTree recursively walks the tree object identified by sha, adding
+// "<prefix>/<name>" -> blob SHA entries for every blob it finds into out. It
+// returns false if it had to give up partway through because an object
+// wasn't available as a loose object.
+func flattenTree(gitDir, sha, prefix string, out map[string]string) bool {
+	objType, content, err := readLooseObject(gitDir, sha)
+	if err != nil || objType != "tree" {
+		return false
+	}
+
+	for len(content) > 0 {
+		nul := bytes.IndexByte(content, 0)
+		if nul < 0 || nul+21 > len(content) {
+			return false
+		}
+
+		header := string(content[:nul])
+		entrySHA := hex.EncodeToString(content[nul+1 : nul+21])
+
+		parts := strings.SplitN(header, " ", 2)
+		if len(parts) != 2 {
+			return false
+		}
+		mode, name := parts[0], parts[1]
+
+		path := name
+		if prefix != "" {
+			path = prefix + "/" + name
+		}
+
+		if mode == "40000" {
+			if !flattenTree(gitDir, entrySHA, path, out) {
+				return false
+			}
+		} else {
+			out[path] = entrySHA
+		}
+
+		content = content[nul+21:]
+	}
+
+	return true
+}
+
+// headTreeViaGit shells out to `git ls-tree` to build the same repo-relative
+// path -> blob SHA-1 map as readHeadTree/flattenTree, for use once the
+// native walk can no longer see the whole tree as loose objects.
+func headTreeViaGit(repoRoot, sha string) map[string]string {
+	tree := map[string]string{}
+
+	out, err := exec.Command("git", "-C", repoRoot, "ls-tree", "-r", "--full-tree", "-z", sha).Output()
+	if err != nil {
+		return tree
+	}
+
+	for _, entry := range strings.Split(strings.TrimRight(string(out), "\x00"), "\x00") {
+		if entry == "" {
+			continue
+		}
+
+		// each entry looks like "<mode> <type> <sha>\t<path>"
+		tab := strings.IndexByte(entry, '\t')
+		if tab < 0 {
+			continue
+		}
+
+		meta := strings.Fields(entry[:tab])
+		if len(meta) != 3 {
+			continue
+		}
+
+		tree[entry[tab+1:]] = meta[2]
+	}
+
+	return tree
+}
+
+// countUntrackedFiles walks the working tree looking for files that aren't
+// present in the index, skipping anything matched by the root `.gitignore`
+// or `.git/info/exclude` so build artifacts, dependency directories, etc.
+// don't get counted (or walked into at all).
+func countUntrackedFiles(repoRoot string, indexed map[string]bool) int {
+	rules := loadIgnoreRules(repoRoot)
+	count := 0
+
+	filepath.Walk(repoRoot, func(walkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if walkPath == repoRoot {
+			return nil
+		}
+
+		rel, err := filepath.Rel(repoRoot, walkPath)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			if info.Name() == ".git" || isIgnored(rules, rel, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if isIgnored(rules, rel, false) {
+			return nil
+		}
+
+		if !indexed[rel] {
+			count++
+		}
+
+		return nil
+	})
+
+	return count
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is one line out of a `.gitignore`-style file.
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// loadIgnoreRules reads the ignore patterns that apply repo-wide: the root
+// `.gitignore` and `.git/info/exclude`. It doesn't look at `.gitignore`
+// files in subdirectories, so nested ignore rules won't be honored - a
+// reasonable tradeoff given most repos declare their build artifacts and
+// dependency directories (node_modules, target/, etc.) at the root.
+func loadIgnoreRules(repoRoot string) []ignoreRule {
+	rules := parseIgnoreFile(filepath.Join(repoRoot, ".gitignore"))
+	rules = append(rules, parseIgnoreFile(filepath.Join(repoRoot, ".git", "info", "exclude"))...)
+	return rules
+}
+
+// parseIgnoreFile parses a single gitignore-format file into rules.
+func parseIgnoreFile(path string) []ignoreRule {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	rules := []ignoreRule{}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{}
+
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		if strings.HasPrefix(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		} else if strings.Contains(line, "/") {
+			// a slash anywhere but the end also anchors the pattern to the
+			// directory the ignore file lives in
+			rule.anchored = true
+		}
+
+		if line == "" {
+			continue
+		}
+
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// isIgnored reports whether relPath (slash-separated, relative to the repo
+// root) should be treated as ignored, applying rules in order so that a
+// later negated (`!pattern`) rule can re-include something an earlier rule
+// excluded.
+func isIgnored(rules []ignoreRule, relPath string, isDir bool) bool {
+	ignored := false
+
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		if matchIgnoreRule(rule, relPath) {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
+
+// matchIgnoreRule reports whether rule's pattern matches relPath, either
+// anchored to the repo root or, for patterns with no slash in them, against
+// any path segment.
+func matchIgnoreRule(rule ignoreRule, relPath string) bool {
+	if rule.anchored {
+		ok, _ := filepath.Match(rule.pattern, relPath)
+		return ok
+	}
+
+	ok, _ := filepath.Match(rule.pattern, filepath.Base(relPath))
+	return ok
+}
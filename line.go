@@ -2,21 +2,23 @@ package main
 
 import (
 	"crypto/md5"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
 )
 
-// locates this directory's parent `.git` directory and returns it, or an error
-// if no parent `.git` directory could be found.
-func gitPath() (string, error) {
+// locates the working tree root (the directory containing a `.git`
+// directory) that contains the current directory, or returns an error if
+// none could be found.
+func findRepoRoot() (string, error) {
 	// start at the current directory
 	cur, err := os.Getwd()
 	if err != nil {
@@ -43,9 +45,9 @@ func gitPath() (string, error) {
 		for _, info := range children {
 			name := info.Name()
 
-			// if we find a directory with the appropriate name, return its path
+			// if we find a directory with the appropriate name, this is the root
 			if name == gitDirectoryName && info.IsDir() {
-				return path.Join(cur, name), nil
+				return cur, nil
 			}
 		}
 
@@ -58,71 +60,6 @@ func gitPath() (string, error) {
 	return "", fmt.Errorf("No Git directory found.")
 }
 
-// finds the current branch of the current Git repository
-func gitCurrentBranch() string {
-	gitPath, err := gitPath()
-	if err != nil {
-		return ""
-	}
-
-	// this file contains a pointer to the current branch which we can parse to
-	// determine the branch name.
-	headPath := path.Join(gitPath, "HEAD")
-
-	// read the HEAD file
-	data, err := ioutil.ReadFile(headPath)
-	if err != nil {
-		return ""
-	}
-
-	refSpec := strings.TrimSpace(string(data))
-
-	// parse the HEAD file to get the branch name. the HEAD file contents look
-	// something like: `ref: refs/heads/master`. we split into three parts, then
-	// use whatever's left over as the branch name. If it doesn't split, it's
-	// probably a commit hash, in which case we use the first 8 characters of it
-	// as the branch name.
-	refSpecParts := strings.SplitN(refSpec, "/", 3)
-	branchName := ""
-	if len(refSpecParts) == 3 {
-		// use the last part as the branch name
-		branchName = strings.TrimSpace(refSpecParts[2])
-	} else if len(refSpecParts) == 1 && len(refSpec) == 40 {
-		// we got a commit hash, use the first 7 characters as the branch name
-		branchName = refSpec[0:7]
-	} else {
-		// notify that we failed
-		branchName = "BAD_REF_SPEC (" + refSpec + ")"
-	}
-
-	// return the third part of our split ref spec, the branch name
-	return branchName
-}
-
-// gets the current status symbols for the existing git repository as a map of
-// file name to status symbol, or nil if there's no repository.
-func gitCurrentStatus() map[string]string {
-	out, err := exec.Command("git", "status", "--porcelain").CombinedOutput()
-	if err != nil {
-		return nil
-	}
-
-	// turn the output into a map of file to status string
-	files := make(map[string]string)
-	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
-		// trim whitespace so we can reliably split out the status/name
-		line = strings.TrimSpace(line)
-
-		// split into a (status, file) pair
-		parts := strings.SplitN(line, " ", 2)
-		if len(parts) == 2 {
-			files[parts[1]] = parts[0]
-		}
-	}
-
-	return files
-}
-
 func compressWithTruncator(s string, truncator rune, maxLen int) string {
 	lenS := utf8.RuneCountInString(s)
 
@@ -349,7 +286,7 @@ func userAndHost() string {
 
 	c := colorHash(user + host)
 
-	return trueColored("[", c) + user + trueColored("@", c) + host + trueColored("]", c)
+	return colored("[", c) + user + colored("@", c) + host + colored("]", c)
 }
 
 func currentTime() string {
@@ -358,35 +295,50 @@ func currentTime() string {
 
 // print the status line!
 func main() {
+	sync := flag.Bool("sync", false, "always compute fresh Git state, bypassing the cache")
+	timeout := flag.Duration("timeout", 0, "max time to wait for fresh Git state before falling back to the cache, e.g. 200ms")
+	refreshCacheFor := flag.String("refresh-cache-for", "", "internal: recompute Git state for the given repo root and write it to the cache")
+	shell := flag.String("shell", ShellPlain, "shell to wrap escape sequences for: bash, zsh, fish, tmux, or plain")
+	flag.Parse()
+
+	// this is how the background process spawned by refreshCacheInBackground
+	// recomputes state: it's not actually rendering a prompt at all.
+	if *refreshCacheFor != "" {
+		if state, err := ReadGitState(*refreshCacheFor); err == nil {
+			writeCache(cachePath(*refreshCacheFor), state)
+		}
+		return
+	}
+
+	activeWriter = NewWriter(*shell)
+
 	cwd, _ := os.Getwd()
-	prettyPath, _ := prettifyPath(cwd, 60)
-	branch := gitCurrentBranch()
-
-	// pick a color for the branch depending on status output
-	branchColor := COLOR_GREEN
-	statuses := gitCurrentStatus()
-	if statuses != nil && len(statuses) > 0 {
-		hasUntracked := false
-		hasModified := false
-
-		for _, status := range statuses {
-			// true if we have untracked or added files
-			hasUntracked = hasUntracked || strings.ContainsAny(status, "A?")
-
-			// true if we have modified, renamed, deleted, or unstaged files
-			hasModified = hasModified || strings.ContainsAny(status, "MRDU")
+
+	exitCode := 0
+	if flag.NArg() > 0 {
+		if parsed, err := strconv.Atoi(flag.Arg(0)); err == nil {
+			exitCode = parsed
+		}
+	}
+
+	ctx := &PromptContext{Cwd: cwd, ExitCode: exitCode}
+	if root, err := findRepoRoot(); err == nil {
+		ctx.Git = resolveGitState(root, *sync, *timeout)
+	}
+
+	cfg := loadConfig()
+
+	rendered := []string{}
+	for _, segCfg := range cfg.Segments {
+		segment := newSegment(segCfg, ctx)
+		if segment == nil {
+			continue
 		}
 
-		if hasUntracked && !hasModified {
-			branchColor = COLOR_YELLOW
-		} else if hasModified {
-			branchColor = COLOR_RED
+		if text, show := segment.Render(); show {
+			rendered = append(rendered, text)
 		}
 	}
 
-	fmt.Printf("┌╼ %s %s %s %s\n└╼ \n",
-		colored(currentTime(), COLOR_MAGENTA),
-		userAndHost(),
-		colored(prettyPath, COLOR_BLUE),
-		colored(branch, branchColor))
+	fmt.Printf("┌╼ %s\n└╼ \n", strings.Join(rendered, " "))
 }
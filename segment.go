@@ -0,0 +1,197 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PromptContext carries the bits of process state that segments need in
+// order to render themselves but that aren't appropriate to read from
+// globals, such as the current working directory and the shell's last exit
+// code.
+type PromptContext struct {
+	Cwd      string
+	ExitCode int
+
+	// Git is nil when the current directory isn't inside a Git repository.
+	Git *GitState
+}
+
+// Segment is a single piece of the rendered prompt, e.g. the current time or
+// the current Git branch. Render returns the text to display and whether the
+// segment should be shown at all; segments that have nothing to show (e.g.
+// the Git branch segment outside of a repository) return false so `main` can
+// skip them entirely rather than leaving a gap.
+type Segment interface {
+	Render() (string, bool)
+}
+
+// newSegment builds the Segment described by cfg and ctx, or nil if cfg.Type
+// names no known segment.
+func newSegment(cfg SegmentConfig, ctx *PromptContext) Segment {
+	switch cfg.Type {
+	case "time":
+		return &timeSegment{cfg}
+	case "userhost":
+		return &userHostSegment{cfg}
+	case "directory":
+		return &directorySegment{cfg, ctx}
+	case "git_branch":
+		return &gitBranchSegment{cfg, ctx}
+	case "git_status":
+		return &gitStatusSegment{cfg, ctx}
+	case "exit_code":
+		return &exitCodeSegment{cfg, ctx}
+	default:
+		return nil
+	}
+}
+
+// color returns the segment's configured color, or white if none was set.
+func (cfg SegmentConfig) color() int {
+	if cfg.Color != nil {
+		return *cfg.Color
+	}
+	return COLOR_WHITE
+}
+
+// wrap applies a segment's configured prefix/suffix and color around s.
+func (cfg SegmentConfig) wrap(s string) string {
+	return cfg.Prefix + colored(s, cfg.color()) + cfg.Suffix
+}
+
+type timeSegment struct {
+	cfg SegmentConfig
+}
+
+func (t *timeSegment) Render() (string, bool) {
+	return t.cfg.wrap(currentTime()), true
+}
+
+type userHostSegment struct {
+	cfg SegmentConfig
+}
+
+func (u *userHostSegment) Render() (string, bool) {
+	// userAndHost picks its own per-host color, so it isn't routed through
+	// cfg.wrap like the other segments.
+	return userAndHost(), true
+}
+
+type directorySegment struct {
+	cfg SegmentConfig
+	ctx *PromptContext
+}
+
+func (d *directorySegment) Render() (string, bool) {
+	prettyPath, err := prettifyPath(d.ctx.Cwd, 60)
+	if err != nil {
+		return "", false
+	}
+
+	return d.cfg.wrap(prettyPath), true
+}
+
+type gitBranchSegment struct {
+	cfg SegmentConfig
+	ctx *PromptContext
+}
+
+func (g *gitBranchSegment) Render() (string, bool) {
+	if g.ctx.Git == nil || g.ctx.Git.Branch == "" {
+		return "", false
+	}
+	state := g.ctx.Git
+
+	// pick a color for the branch depending on status output, unless the user
+	// configured one explicitly.
+	color := COLOR_GREEN
+	if state.Staged+state.Conflicted > 0 || state.Unstaged > 0 {
+		color = COLOR_RED
+	} else if state.Untracked > 0 {
+		color = COLOR_YELLOW
+	}
+
+	if g.cfg.Color != nil {
+		color = *g.cfg.Color
+	}
+
+	return g.cfg.Prefix + colored(state.Branch, color) + g.cfg.Suffix, true
+}
+
+type gitStatusSegment struct {
+	cfg SegmentConfig
+	ctx *PromptContext
+}
+
+func (g *gitStatusSegment) Render() (string, bool) {
+	state := g.ctx.Git
+	if state == nil {
+		return "", false
+	}
+
+	parts := []string{}
+	if state.Operation != "" {
+		parts = append(parts, g.symbol(state.Operation, 1, COLOR_RED, true))
+	}
+	parts = append(parts, g.symbol("⇡", state.Ahead, COLOR_CYAN, false))
+	parts = append(parts, g.symbol("⇣", state.Behind, COLOR_MAGENTA, false))
+	parts = append(parts, g.symbol("✚", state.Staged, COLOR_GREEN, false))
+	parts = append(parts, g.symbol("●", state.Unstaged, COLOR_YELLOW, false))
+	parts = append(parts, g.symbol("?", state.Untracked, COLOR_BLUE, false))
+	parts = append(parts, g.symbol("✖", state.Conflicted, COLOR_RED, false))
+	parts = append(parts, g.symbol("⚑", state.Stashed, COLOR_WHITE, false))
+
+	shown := []string{}
+	for _, part := range parts {
+		if part != "" {
+			shown = append(shown, part)
+		}
+	}
+
+	if len(shown) == 0 {
+		return "", false
+	}
+
+	return g.cfg.Prefix + strings.Join(shown, " ") + g.cfg.Suffix, true
+}
+
+// symbol renders a single status indicator as "<symbol><count>", in its own
+// color unless the segment has an explicit color configured. If bare is true,
+// symbol is rendered on its own with no count (used for the in-progress
+// operation indicator, where symbol is already a whole word).
+func (g *gitStatusSegment) symbol(symbol string, count int, defaultColor int, bare bool) string {
+	if count == 0 {
+		return ""
+	}
+
+	color := defaultColor
+	if g.cfg.Color != nil {
+		color = *g.cfg.Color
+	}
+
+	text := symbol
+	if !bare {
+		text = symbol + strconv.Itoa(count)
+	}
+
+	return colored(text, color)
+}
+
+type exitCodeSegment struct {
+	cfg SegmentConfig
+	ctx *PromptContext
+}
+
+func (e *exitCodeSegment) Render() (string, bool) {
+	if e.ctx.ExitCode == 0 {
+		return "", false
+	}
+
+	color := COLOR_RED
+	if e.cfg.Color != nil {
+		color = *e.cfg.Color
+	}
+
+	return e.cfg.Prefix + colored(strconv.Itoa(e.ctx.ExitCode), color) + e.cfg.Suffix, true
+}
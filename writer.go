@@ -0,0 +1,100 @@
+package main
+
+import "fmt"
+
+// Shell names accepted by the --shell flag.
+const (
+	ShellBash  = "bash"
+	ShellZsh   = "zsh"
+	ShellFish  = "fish"
+	ShellTmux  = "tmux"
+	ShellPlain = "plain"
+)
+
+// Writer renders colored text for a specific shell, wrapping every escape
+// sequence in that shell's non-printing markers so line-editing shells don't
+// miscount the prompt's on-screen width. Without this, setting `line`'s
+// output directly as PS1/PROMPT causes readline/zle to wrap lines in the
+// wrong place as soon as any color is involved.
+type Writer struct {
+	shell string
+	mode  ColorMode
+}
+
+// NewWriter returns a Writer that targets the given shell (one of the Shell*
+// constants; unrecognized values are treated like ShellPlain) using the
+// terminal's detected color support. An explicit non-plain shell means the
+// output is headed for PS1/PROMPT embedding rather than straight to a
+// terminal, so color support is detected from the environment regardless of
+// whether stdout itself is a tty.
+func NewWriter(shell string) *Writer {
+	return &Writer{shell: shell, mode: detectColorMode(shell == ShellPlain)}
+}
+
+// Colored wraps s in the escape sequences needed to render it in hexColor,
+// with each escape sequence itself wrapped in this Writer's shell's
+// non-printing markers.
+func (w *Writer) Colored(s string, hexColor int) string {
+	// tmux's status-line `#[...]` syntax is a fixed style-keyword grammar, not
+	// a passthrough for arbitrary escape sequences, so it needs its own
+	// `#[fg=...]` directives rather than a wrapped ANSI escape.
+	if w.shell == ShellTmux {
+		return w.tmuxColored(s, hexColor)
+	}
+
+	fg := Render(hexColor, w.mode)
+	if fg == "" {
+		return s
+	}
+
+	return w.wrap(fg) + s + w.wrap(COLOR_RESET)
+}
+
+// wrap surrounds a single raw escape sequence in whatever markers tell the
+// target shell "this doesn't take up any space on screen".
+func (w *Writer) wrap(escape string) string {
+	switch w.shell {
+	case ShellBash:
+		return "\\[" + escape + "\\]"
+	case ShellZsh:
+		return "%{" + escape + "%}"
+	case ShellFish, ShellPlain:
+		// fish already tracks prompt width correctly around raw escapes, and
+		// plain output (terminal, file, etc.) has no notion of markers at all.
+		return escape
+	default:
+		return escape
+	}
+}
+
+// tmuxColored wraps s in tmux's `#[fg=...]` style directives instead of a
+// wrapped ANSI escape sequence.
+func (w *Writer) tmuxColored(s string, hexColor int) string {
+	style := w.tmuxStyle(hexColor)
+	if style == "" {
+		return s
+	}
+
+	return "#[fg=" + style + "]" + s + "#[fg=default]"
+}
+
+// tmuxStyle returns the tmux color spec for hexColor under this Writer's
+// color mode, or "" for ColorNone.
+func (w *Writer) tmuxStyle(hexColor int) string {
+	switch w.mode {
+	case ColorTrue:
+		return fmt.Sprintf("#%06x", hexColor)
+	case Color256:
+		return fmt.Sprintf("colour%d", hexTo256(hexColor))
+	case Color16:
+		return fmt.Sprintf("colour%d", hexTo16(hexColor))
+	default:
+		return ""
+	}
+}
+
+// activeWriter is the Writer `colored` renders through. main sets it from
+// the --shell flag before rendering any segments; it defaults to a
+// no-color plain Writer so any code path that renders before main gets the
+// chance to (e.g. tests) still produces clean output rather than panicking.
+var activeWriter = &Writer{shell: ShellPlain, mode: ColorNone}